@@ -3,27 +3,46 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+
 	batch "github.com/TowelSoftware/Mastersteam/batch"
+	"github.com/TowelSoftware/Mastersteam/cache"
+	"github.com/TowelSoftware/Mastersteam/store"
 	valve "github.com/TowelSoftware/Mastersteam/valve"
 )
 
-var (
-	sOutputBuffer bytes.Buffer
-	sNumServers   int64
-	master        *valve.MasterServerQuerier
-)
+// db persists every queried server and player sample for the /history/
+// endpoints. It stays nil (history disabled) until main() configures a
+// store backend.
+var db *store.Store
+
+// responseCache memoizes /search/ and /server/ responses so repeated
+// lookups don't have to re-query Valve's master within the TTL window. It
+// defaults to a Noop cache until main() configures a real backend.
+var responseCache cache.Cache = cache.Noop{}
+
+// httpLimiters enforces the per-client-IP HTTP rate limit. It's replaced
+// in main() once flags have been parsed.
+var httpLimiters = newIPLimiters(rate.Limit(2), 5)
 
 /*
 ErrorObject ...
@@ -57,112 +76,346 @@ type ServerObject struct {
 	GameMode    string      `json:"game_mode,omitempty"`
 	GameID      string      `json:"gameid,omitempty"`
 
-	PlayersOnline []*valve.Player `json:"players_online,omitempty"`
+	PlayersOnline []*valve.Player   `json:"players_online,omitempty"`
+	Rules         map[string]string `json:"rules,omitempty"`
+}
+
+/*
+resultStream streams query results to an HTTP client as each server answers,
+instead of buffering the whole response in a package-level byte buffer. It
+is created fresh per request, so two concurrent requests never touch each
+other's state.
+
+By default it writes a single JSON object (`{"data": [{...}], "total": n}`),
+flushing after every server so clients consuming large master lists see
+results incrementally. If the client sends "Accept: application/x-ndjson"
+it instead writes one JSON object per line as soon as each server answers.
+*/
+type resultStream struct {
+	mu       sync.Mutex
+	w        http.ResponseWriter
+	out      io.Writer
+	flusher  http.Flusher
+	ndjson   bool
+	count    int64
+	cacheBuf *bytes.Buffer
+}
+
+func newResultStream(w http.ResponseWriter, r *http.Request) *resultStream {
+	ndjson := strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+
+	if ndjson {
+		w.Header().Set("Content-Type", "application/x-ndjson; charset=UTF-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	}
+
+	return &resultStream{
+		w:       w,
+		out:     w,
+		flusher: flusher,
+		ndjson:  ndjson,
+	}
 }
 
-func addJSON(hostAndPort string, obj interface{}) {
+// teeToCache additionally buffers everything written so the caller can
+// cache the full response once the stream finishes. It's only meaningful
+// for the non-ndjson mode, whose output is a single cacheable document.
+func (rs *resultStream) teeToCache() {
+	rs.cacheBuf = &bytes.Buffer{}
+	rs.out = io.MultiWriter(rs.w, rs.cacheBuf)
+}
+
+func (rs *resultStream) begin() {
+	if !rs.ndjson {
+		fmt.Fprint(rs.out, "{\n\t\"data\": [{")
+	}
+}
+
+func (rs *resultStream) add(hostAndPort string, obj interface{}) {
 	buf, err := json.Marshal(obj)
 	if err != nil {
 		panic(err)
 	}
 
-	var indented bytes.Buffer
-	json.Indent(&indented, buf, "\t", "\t")
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
 
-	if sNumServers != 0 {
-		sOutputBuffer.WriteString(",")
+	if rs.ndjson {
+		rs.out.Write(buf)
+		fmt.Fprint(rs.out, "\n")
+	} else {
+		if rs.count != 0 {
+			fmt.Fprint(rs.out, ",")
+		}
+		fmt.Fprintf(rs.out, "\n\t\t%q: %s", hostAndPort, buf)
 	}
 
-	sOutputBuffer.WriteString(fmt.Sprintf("\n\t\"%s\": ", hostAndPort))
-	sOutputBuffer.WriteString(indented.String())
+	rs.count++
 
-	sNumServers++
+	if rs.flusher != nil {
+		rs.flusher.Flush()
+	}
 }
 
-func addError(hostAndPort string, err error) {
-	addJSON(hostAndPort, &ErrorObject{
+func (rs *resultStream) addError(hostAndPort string, err error) {
+	rs.add(hostAndPort, &ErrorObject{
 		IP:    hostAndPort,
 		Error: err.Error(),
 	})
 }
 
+func (rs *resultStream) finish() {
+	if !rs.ndjson {
+		fmt.Fprintf(rs.out, "\n\t}],\n\t\"total\": %d\n}\n", rs.count)
+	}
+}
+
 /*
 Log ...
 */
 func Log(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("access: %s %s %s", r.RemoteAddr, r.Method, r.URL)
+		log.Info().Str("remote", r.RemoteAddr).Str("method", r.Method).Str("url", r.URL.String()).Msg("access")
 		handler.ServeHTTP(w, r)
 	})
 }
 
 func httpMasterSearch(w http.ResponseWriter, r *http.Request) {
-	uriSegments := strings.Split(r.URL.String(), "/")
-	appID, _ := strconv.Atoi(uriSegments[2])
-	hostname, _ := url.QueryUnescape(uriSegments[3])
+	vars := mux.Vars(r)
+	appID, _ := strconv.Atoi(vars["appid"])
+	hostname := vars["hostname"]
+
+	ndjson := strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+	withRules := r.URL.Query().Get("rules") == "1"
+	source := r.URL.Query().Get("source")
+	cacheKey := fmt.Sprintf("search:%d:%s:rules=%t:source=%s", appID, hostname, withRules, source)
+	if !ndjson {
+		if cached, err := responseCache.Get(r.Context(), cacheKey); err == nil {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Write(cached)
+			return
+		}
+	}
 
-	newMasterServerQuerier()
+	master, err := newMasterServerQuerier(source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer master.Close()
 
 	// Set up the filter list.
 	master.FilterAppId(valve.AppId(appID))
 	master.FilterName(hostname)
 
-	newServerQuerier()
-
-	//defer master.Close()
+	rs := newResultStream(w, r)
+	if !ndjson {
+		rs.teeToCache()
+	}
+	rs.begin()
+	opts := defaultServerQueryOptions()
+	opts.WithRules = withRules
+	timer := prometheus.NewTimer(queryLatency.WithLabelValues("search"))
+	err = runServerQuerier(master, rs, opts)
+	timer.ObserveDuration()
+	if err != nil {
+		log.Error().Err(err).Msg("could not query the master")
+	}
+	rs.finish()
 
-	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	fmt.Fprintf(w, "%s", sOutputBuffer.String())
+	if rs.cacheBuf != nil {
+		responseCache.Set(r.Context(), cacheKey, rs.cacheBuf.Bytes(), *flagCacheTTLSearch)
+	}
 }
 
 func httpServer(w http.ResponseWriter, r *http.Request) {
-	uriSegments := strings.Split(r.URL.String(), "/")
-	host, _ := url.QueryUnescape(uriSegments[2])
+	host := mux.Vars(r)["gameaddr"]
+
+	ndjson := strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+	withRules := r.URL.Query().Get("rules") == "1"
+	source := r.URL.Query().Get("source")
+	cacheKey := fmt.Sprintf("server:%s:rules=%t:source=%s", host, withRules, source)
+	if !ndjson {
+		if cached, err := responseCache.Get(r.Context(), cacheKey); err == nil {
+			w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+			w.Write(cached)
+			return
+		}
+	}
 
-	newMasterServerQuerier()
+	master, err := newMasterServerQuerier(source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer master.Close()
 
 	master.FilterGameaddr(host)
 
-	newServerQuerier()
+	rs := newResultStream(w, r)
+	if !ndjson {
+		rs.teeToCache()
+	}
+	rs.begin()
+	opts := defaultServerQueryOptions()
+	opts.WithRules = withRules
+	timer := prometheus.NewTimer(queryLatency.WithLabelValues("server"))
+	err = runServerQuerier(master, rs, opts)
+	timer.ObserveDuration()
+	if err != nil {
+		log.Error().Err(err).Msg("could not query the master")
+	}
+	rs.finish()
+
+	if rs.cacheBuf != nil {
+		responseCache.Set(r.Context(), cacheKey, rs.cacheBuf.Bytes(), *flagCacheTTLServer)
+	}
+}
+
+// recordHistory persists a single query result so /history/server/{addr}
+// and /history/player/{name} have something to read. Failures are logged,
+// not surfaced, since history is best-effort and shouldn't fail a request.
+func recordHistory(addr string, out *ServerObject) {
+	ctx := context.Background()
+
+	if err := db.RecordServer(ctx, store.ServerSnapshot{
+		Address:    addr,
+		AppID:      int(out.AppID),
+		Name:       out.Name,
+		MapName:    out.MapName,
+		Players:    int(out.Players),
+		MaxPlayers: int(out.MaxPlayers),
+	}); err != nil {
+		log.Error().Err(err).Msg("history")
+	}
+
+	if len(out.PlayersOnline) == 0 {
+		return
+	}
+
+	sightings := make([]store.PlayerSighting, len(out.PlayersOnline))
+	for i, p := range out.PlayersOnline {
+		sightings[i] = store.PlayerSighting{
+			Name:     p.Name,
+			Score:    int32(p.Score),
+			Duration: p.Duration,
+		}
+	}
+
+	if err := db.RecordPlayers(ctx, addr, sightings); err != nil {
+		log.Error().Err(err).Msg("history")
+	}
+}
 
-	//defer master.Close()
+func httpServerHistory(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "history is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	addr := mux.Vars(r)["addr"]
+
+	history, err := db.ServerHistory(r.Context(), addr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-	fmt.Fprintf(w, "%s", sOutputBuffer.String())
+	json.NewEncoder(w).Encode(history)
 }
 
-func newMasterServerQuerier() {
-	m, err := valve.NewMasterServerQuerier(valve.MasterServer)
+func httpPlayerHistory(w http.ResponseWriter, r *http.Request) {
+	if db == nil {
+		http.Error(w, "history is not configured", http.StatusNotImplemented)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+
+	history, err := db.PlayerHistory(r.Context(), name)
 	if err != nil {
-		log.Printf("Could not query master: %s", err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	master = m
-	//defer m.Close()
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(history)
 }
 
-func newServerQuerier() {
-	flagTimeout := time.Second * 3
-	flagJ := 20
-	sNumServers = 0
+// newMasterServerQuerier opens a fresh master connection for a single
+// request, selecting the backend named by source ("udp" or "web", falling
+// back to flagMasterSource when source is empty). It used to be stashed in
+// a package-level variable shared (and raced on) by every in-flight
+// request.
+func newMasterServerQuerier(source string) (valve.MasterQuerier, error) {
+	if source == "" {
+		source = *flagMasterSource
+	}
 
-	sOutputBuffer.Reset()
+	switch source {
+	case "web":
+		if *flagSteamAPIKey == "" {
+			return nil, fmt.Errorf("the web master backend requires -steam-api-key")
+		}
+		return valve.NewWebMasterServerQuerier(*flagSteamAPIKey), nil
+	case "udp", "":
+		m, err := valve.NewMasterServerQuerier(valve.MasterServer)
+		if err != nil {
+			return nil, fmt.Errorf("could not query master: %s", err.Error())
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("unknown master source %q", source)
+	}
+}
+
+// serverQueryOptions controls what runServerQuerier does for each server
+// it finds, so /v1/servers can expose them as the include/timeout/
+// concurrency query parameters while /search/ and /server/ keep their
+// original fixed behavior.
+type serverQueryOptions struct {
+	Timeout     time.Duration
+	Concurrency int
+	Limit       int
+	WithPlayers bool
+	WithRules   bool
+}
 
+func defaultServerQueryOptions() serverQueryOptions {
+	return serverQueryOptions{
+		Timeout:     3 * time.Second,
+		Concurrency: 20,
+		WithPlayers: true,
+	}
+}
+
+// runServerQuerier drives the master query and streams every answering
+// server into rs as soon as its batch worker finishes, rather than
+// collecting the whole list before writing anything out.
+func runServerQuerier(master valve.MasterQuerier, rs *resultStream, opts serverQueryOptions) error {
 	bp := batch.NewBatchProcessor(func(item interface{}) {
+		defer batchQueueDepth.Dec()
+
 		addr := item.(*net.TCPAddr)
-		query, err := valve.NewServerQuerier(addr.String(), flagTimeout)
+		query, err := valve.NewServerQuerier(addr.String(), opts.Timeout)
 		if err != nil {
-			addError(addr.String(), err)
+			observeA2S("info", err)
+			rs.addError(addr.String(), err)
 			return
 		}
 		defer query.Close()
 
 		info, err := query.QueryInfo()
+		observeA2S("info", err)
 		if err != nil {
-			addError(addr.String(), err)
+			rs.addError(addr.String(), err)
 			return
 		}
 
-		log.Printf("%s - %s\n", addr.String(), info.Name)
+		log.Info().Str("addr", addr.String()).Str("name", info.Name).Msg("queried server")
 
 		out := &ServerObject{
 			Address:    addr.String(),
@@ -194,8 +447,9 @@ func newServerQuerier() {
 			out.GameID = fmt.Sprintf("%d", info.Ext.GameId)
 		}
 
-		if info.Players > 0 {
+		if opts.WithPlayers && info.Players > 0 {
 			players, err := query.QueryPlayers()
+			observeA2S("players", err)
 			if err != nil {
 				out.PlayersOnline = nil
 			} else {
@@ -203,44 +457,102 @@ func newServerQuerier() {
 			}
 		}
 
-		addJSON(addr.String(), out)
-	}, flagJ)
+		if opts.WithRules {
+			rules, err := query.QueryRules()
+			observeA2S("rules", err)
+			if err == nil {
+				out.Rules = rules
+			}
+		}
+
+		if db != nil {
+			recordHistory(addr.String(), out)
+		}
+
+		rs.add(addr.String(), out)
+	}, opts.Concurrency)
 
 	defer bp.Terminate()
 
-	// TOP OF JSON FILE
-	sOutputBuffer.WriteString("{\n")
-	sOutputBuffer.WriteString("\t\"data\" : [{")
+	// Valve rate-limits master queries aggressively; wait our turn before
+	// issuing another one.
+	if err := masterLimiter.Wait(context.Background()); err != nil {
+		return fmt.Errorf("rate limiter: %s", err.Error())
+	}
 
-	// Query the master.
+	// Query the master, capping the number of servers handed to the batch
+	// processor at opts.Limit (0 means unlimited).
+	masterQueriesTotal.Inc()
+	queried := 0
 	err := master.Query(func(servers valve.ServerList) error {
+		if opts.Limit > 0 {
+			if queried >= opts.Limit {
+				return nil
+			}
+			if remaining := opts.Limit - queried; len(servers) > remaining {
+				servers = servers[:remaining]
+			}
+		}
+		queried += len(servers)
+		serversReturnedTotal.Add(float64(len(servers)))
+		batchQueueDepth.Add(float64(len(servers)))
 		bp.AddBatch(servers)
 		return nil
 	})
-
 	if err != nil {
-		log.Printf("Could not query the master: %s\n", err.Error())
-		os.Exit(1)
+		return fmt.Errorf("could not query the master: %s", err.Error())
 	}
 
 	// Wait for batch processing to complete.
 	bp.Finish()
 
-	if sNumServers != 0 {
-		//sOutputBuffer.WriteString("\n")
-	}
-
-	sOutputBuffer.WriteString("}],\n")
-	sOutputBuffer.WriteString(fmt.Sprintf("\t\"total\":%d\n", sNumServers))
-	sOutputBuffer.WriteString("}\n")
-	//BOTTOM OF JSON FILE
-
+	return nil
 }
 
 func main() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	http.HandleFunc("/search/", httpMasterSearch)
-	http.HandleFunc("/server/", httpServer)
-	log.Fatal(http.ListenAndServe(":8080", Log(http.DefaultServeMux)))
+	flag.Parse()
+
+	responseCache = newResponseCache()
+	httpLimiters = newIPLimiters(rate.Limit(*flagRateHTTP), *flagRateHTTPBurst)
+	masterLimiter = rate.NewLimiter(rate.Limit(*flagRateMaster), *flagRateMasterBurst)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if *flagStoreDriver != "" {
+		opened, err := store.Open(*flagStoreDriver, *flagStoreDSN, *flagStoreMigrateUnsafe)
+		if err != nil {
+			log.Fatal().Err(err).Msg("could not open history store")
+		}
+		db = opened
+		defer db.Close()
+
+		go refreshKnownServers(ctx, db, *flagStoreRefresh)
+	}
+
+	srv := &http.Server{
+		Addr:    ":8080",
+		Handler: RateLimit(httpLimiters, Log(newRouter())),
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal().Err(err).Msg("could not serve")
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+
+	log.Info().Msg("shutting down")
+	cancel()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("could not shut down cleanly")
+	}
 }
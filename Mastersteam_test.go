@@ -0,0 +1,102 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// TestResultStreamConcurrentAdds drives many goroutines adding to the same
+// resultStream at once, the way runServerQuerier's batch workers do. Run
+// with -race: a shared package-level buffer (the pre-chunk0-1 design)
+// would corrupt interleaved writes or miscount; a per-request stream
+// guarded by its own mutex should not.
+func TestResultStreamConcurrentAdds(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/search/10/test", nil)
+
+	rs := newResultStream(rec, req)
+	rs.begin()
+
+	const workers = 50
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			rs.add(fmt.Sprintf("127.0.0.1:%d", 27015+i), &ServerObject{Name: fmt.Sprintf("server-%d", i)})
+		}(i)
+	}
+	wg.Wait()
+	rs.finish()
+
+	if rs.count != int64(workers) {
+		t.Fatalf("count = %d, want %d", rs.count, workers)
+	}
+
+	var decoded struct {
+		Data  []map[string]ServerObject `json:"data"`
+		Total int                       `json:"total"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("response is not valid JSON: %s\nbody: %s", err, rec.Body.String())
+	}
+	if decoded.Total != workers {
+		t.Fatalf("total = %d, want %d", decoded.Total, workers)
+	}
+	if len(decoded.Data) != 1 || len(decoded.Data[0]) != workers {
+		t.Fatalf("got %d results, want %d", len(decoded.Data[0]), workers)
+	}
+}
+
+// TestResultStreamIsolatedPerRequest runs two resultStreams concurrently,
+// proving two in-flight requests don't see each other's results the way
+// they would if they shared a package-level buffer.
+func TestResultStreamIsolatedPerRequest(t *testing.T) {
+	run := func(serverName string, n int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/search/10/test", nil)
+
+		rs := newResultStream(rec, req)
+		rs.begin()
+		for i := 0; i < n; i++ {
+			rs.add(fmt.Sprintf("127.0.0.1:%d", 27015+i), &ServerObject{Name: serverName})
+		}
+		rs.finish()
+		return rec
+	}
+
+	var recA, recB *httptest.ResponseRecorder
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); recA = run("request-a", 10) }()
+	go func() { defer wg.Done(); recB = run("request-b", 25) }()
+	wg.Wait()
+
+	for name, rec := range map[string]*httptest.ResponseRecorder{"a": recA, "b": recB} {
+		var decoded struct {
+			Data []map[string]ServerObject `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+			t.Fatalf("request %s: response is not valid JSON: %s", name, err)
+		}
+		if len(decoded.Data) != 1 {
+			t.Fatalf("request %s: expected one data object, got %d", name, len(decoded.Data))
+		}
+		for addr, obj := range decoded.Data[0] {
+			wantName := "request-a"
+			if name == "b" {
+				wantName = "request-b"
+			}
+			if obj.Name != wantName {
+				t.Fatalf("request %s: server %s has name %q from the other request", name, addr, obj.Name)
+			}
+		}
+	}
+	if len(recA.Body.String()) == 0 || len(recB.Body.String()) == 0 {
+		t.Fatal("expected both requests to produce output")
+	}
+}
@@ -0,0 +1,137 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+
+// Package cache provides a pluggable response cache for the expensive
+// master and A2S queries the HTTP handlers make, so repeated lookups for
+// the same key don't have to pay Valve's round-trip (and Valve's
+// rate-limiting) again within the TTL window.
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	redisCache "github.com/go-redis/cache/v8"
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrMiss is returned by Get when key is not present.
+var ErrMiss = errors.New("cache: miss")
+
+/*
+Cache is the interface the HTTP handlers use to memoize responses. value
+is always raw response bytes; callers are responsible for (de)serializing
+their own payloads.
+*/
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+/*
+Redis is a Cache backed by go-redis/cache, which layers a small in-process
+TinyLFU cache on top of the shared Redis tier so hot keys don't round-trip
+to Redis on every request either.
+*/
+type Redis struct {
+	c *redisCache.Cache
+}
+
+// NewRedis dials addr and wraps it as a Cache.
+func NewRedis(addr string) *Redis {
+	ring := redis.NewRing(&redis.RingOptions{
+		Addrs: map[string]string{"server": addr},
+	})
+
+	return &Redis{
+		c: redisCache.New(&redisCache.Options{
+			Redis:      ring,
+			LocalCache: redisCache.NewTinyLFU(1000, time.Minute),
+		}),
+	}
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	if err := r.c.Get(ctx, key, &value); err != nil {
+		if errors.Is(err, redisCache.ErrCacheMiss) {
+			return nil, ErrMiss
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.c.Set(&redisCache.Item{
+		Ctx:   ctx,
+		Key:   key,
+		Value: value,
+		TTL:   ttl,
+	})
+}
+
+// Noop is a Cache that never stores anything. Callers that explicitly
+// want caching disabled can use it instead of a nil check.
+type Noop struct{}
+
+func (Noop) Get(ctx context.Context, key string) ([]byte, error) { return nil, ErrMiss }
+func (Noop) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return nil
+}
+
+// memoryEntry is one cached value and when it stops being valid.
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+/*
+Memory is an in-process Cache, the pluggable fallback for deployments
+that don't run Redis. It trades Redis's cross-process sharing for zero
+setup: entries live only in this process's memory and are lost on
+restart, but that's fine for a response cache whose entries are cheap to
+recompute from Valve.
+*/
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+// NewMemory builds an empty in-process Cache.
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]memoryEntry)}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, ErrMiss
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(m.entries, key)
+		return nil, ErrMiss
+	}
+	return entry.value, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Evict anything else that's already expired while we hold the lock,
+	// so a cache that's never read from doesn't grow unbounded.
+	now := time.Now()
+	for k, e := range m.entries {
+		if now.After(e.expiresAt) {
+			delete(m.entries, k)
+		}
+	}
+
+	m.entries[key] = memoryEntry{value: value, expiresAt: now.Add(ttl)}
+	return nil
+}
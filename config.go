@@ -0,0 +1,83 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"flag"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/TowelSoftware/Mastersteam/cache"
+)
+
+// getenvOr returns os.Getenv(key) if set, otherwise def. Flags below use it
+// so most options can also be set via the environment for container
+// deployments; the cache TTL, store refresh interval and
+// store-migrate-unsafe flags have no environment variable and are set on
+// the command line only.
+func getenvOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+func getenvFloatOr(key string, def float64) float64 {
+	if v, ok := os.LookupEnv(key); ok {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+func getenvIntOr(key string, def int) int {
+	if v, ok := os.LookupEnv(key); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+var (
+	flagRedisAddr = flag.String("redis-addr", getenvOr("MASTERSTEAM_REDIS_ADDR", ""),
+		"address of a Redis server to cache query results in (uses an in-process cache if empty)")
+	flagCacheTTLSearch = flag.Duration("cache-ttl-search", 30*time.Second,
+		"how long to cache /search/ results for")
+	flagCacheTTLServer = flag.Duration("cache-ttl-server", 15*time.Second,
+		"how long to cache /server/ results for")
+
+	flagRateMaster = flag.Float64("rate-master", getenvFloatOr("MASTERSTEAM_RATE_MASTER", 10),
+		"max master server queries per second (Valve bans IPs that query it too aggressively)")
+	flagRateMasterBurst = flag.Int("rate-master-burst", getenvIntOr("MASTERSTEAM_RATE_MASTER_BURST", 5),
+		"burst size for the master server query rate limit")
+
+	flagRateHTTP = flag.Float64("rate-http", getenvFloatOr("MASTERSTEAM_RATE_HTTP", 2),
+		"max HTTP requests per second allowed per client IP")
+	flagRateHTTPBurst = flag.Int("rate-http-burst", getenvIntOr("MASTERSTEAM_RATE_HTTP_BURST", 5),
+		"burst size for the per-client HTTP rate limit")
+
+	flagStoreDriver = flag.String("store-driver", getenvOr("MASTERSTEAM_STORE_DRIVER", ""),
+		"ent driver for the history store, e.g. sqlite3 or postgres (disables history if empty)")
+	flagStoreDSN = flag.String("store-dsn", getenvOr("MASTERSTEAM_STORE_DSN", "file:mastersteam.db?_fk=1"),
+		"data source name for the history store")
+	flagStoreRefresh = flag.Duration("store-refresh-interval", 5*time.Minute,
+		"how often the housekeeping goroutine re-queries known servers")
+	flagStoreMigrateUnsafe = flag.Bool("store-migrate-unsafe", false,
+		"allow the history store's auto-migration to drop indexes/columns not in the current schema (never set this against a production database)")
+
+	flagMasterSource = flag.String("master-source", getenvOr("MASTERSTEAM_MASTER_SOURCE", "udp"),
+		"default master backend, \"udp\" or \"web\" (overridable per-request with ?source=)")
+	flagSteamAPIKey = flag.String("steam-api-key", getenvOr("MASTERSTEAM_STEAM_API_KEY", ""),
+		"Steam Web API key, required to use the \"web\" master backend")
+)
+
+// newResponseCache builds the configured Cache backend, falling back to
+// an in-process Memory cache when no Redis address is set.
+func newResponseCache() cache.Cache {
+	if *flagRedisAddr == "" {
+		return cache.NewMemory()
+	}
+	return cache.NewRedis(*flagRedisAddr)
+}
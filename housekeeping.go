@@ -0,0 +1,93 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/TowelSoftware/Mastersteam/store"
+	valve "github.com/TowelSoftware/Mastersteam/valve"
+)
+
+// refreshKnownServers periodically re-queries every server db has seen
+// before, so population history keeps accumulating even between client
+// requests. It runs until ctx is cancelled.
+func refreshKnownServers(ctx context.Context, db *store.Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			addrs, err := db.KnownAddresses(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("housekeeping: could not list known servers")
+				continue
+			}
+
+			for _, addr := range addrs {
+				refreshServer(ctx, db, addr)
+			}
+		}
+	}
+}
+
+func refreshServer(ctx context.Context, db *store.Store, addr string) {
+	if err := masterLimiter.Wait(ctx); err != nil {
+		return
+	}
+
+	query, err := valve.NewServerQuerier(addr, 3*time.Second)
+	if err != nil {
+		log.Error().Err(err).Str("addr", addr).Msg("housekeeping: could not query server")
+		return
+	}
+	defer query.Close()
+
+	info, err := query.QueryInfo()
+	if err != nil {
+		log.Error().Err(err).Str("addr", addr).Msg("housekeeping: could not query server")
+		return
+	}
+
+	snap := store.ServerSnapshot{
+		Address:    addr,
+		Name:       info.Name,
+		MapName:    info.MapName,
+		Players:    int(info.Players),
+		MaxPlayers: int(info.MaxPlayers),
+	}
+	if info.Ext != nil {
+		snap.AppID = int(info.Ext.AppId)
+	}
+
+	if err := db.RecordServer(ctx, snap); err != nil {
+		log.Error().Err(err).Msg("housekeeping")
+	}
+
+	if info.Players == 0 {
+		return
+	}
+
+	players, err := query.QueryPlayers()
+	if err != nil {
+		return
+	}
+
+	sightings := make([]store.PlayerSighting, len(players))
+	for i, p := range players {
+		sightings[i] = store.PlayerSighting{
+			Name:     p.Name,
+			Score:    int32(p.Score),
+			Duration: p.Duration,
+		}
+	}
+
+	if err := db.RecordPlayers(ctx, addr, sightings); err != nil {
+		log.Error().Err(err).Msg("housekeeping")
+	}
+}
@@ -0,0 +1,67 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"errors"
+	"net"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics give operators visibility into UDP timeout rates, master query
+// volume, and batch worker saturation that previously had no counterpart
+// besides grepping stdlib log output. Master query and server counts are
+// totals; they are not partitioned by appid.
+var (
+	masterQueriesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mastersteam_master_queries_total",
+		Help: "Number of master server queries issued.",
+	})
+
+	serversReturnedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mastersteam_servers_returned_total",
+		Help: "Number of servers returned by the master across all queries.",
+	})
+
+	a2sQueriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mastersteam_a2s_queries_total",
+		Help: "Number of A2S sub-queries issued, by kind (info, players, rules) and result (success, timeout, other).",
+	}, []string{"kind", "result"})
+
+	queryLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mastersteam_query_duration_seconds",
+		Help:    "Latency of a full master+A2S query, by HTTP endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	// batchQueueDepth is a gauge, not a histogram: operators need "how
+	// backed up is the queue right now", a point-in-time reading, not a
+	// distribution of depths over time.
+	batchQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "mastersteam_batch_queue_depth",
+		Help: "Number of servers currently queued for A2S querying.",
+	})
+)
+
+// classifyA2SError buckets an A2S sub-query error into a result label:
+// "success" for no error, "timeout" for a network timeout (the common
+// case when a listed server has gone offline), "other" for anything
+// else (e.g. a malformed reply).
+func classifyA2SError(err error) string {
+	if err == nil {
+		return "success"
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return "other"
+}
+
+// observeA2S records the outcome of a single A2S sub-query (info, players,
+// or rules), partitioned by kind and result.
+func observeA2S(kind string, err error) {
+	a2sQueriesTotal.WithLabelValues(kind, classifyA2SError(err)).Inc()
+}
@@ -0,0 +1,63 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// masterLimiter throttles outgoing UDP queries to Valve's master server.
+// Valve bans IPs that query it too aggressively, so every request path
+// that talks to the master shares this single limiter. It's replaced in
+// main() once flags have been parsed.
+var masterLimiter = rate.NewLimiter(rate.Limit(10), 5)
+
+// ipLimiters holds one limiter per client IP for the HTTP-facing rate
+// limit, created lazily on first use.
+type ipLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	rate     rate.Limit
+	burst    int
+}
+
+func newIPLimiters(r rate.Limit, burst int) *ipLimiters {
+	return &ipLimiters{
+		limiters: make(map[string]*rate.Limiter),
+		rate:     r,
+		burst:    burst,
+	}
+}
+
+func (l *ipLimiters) get(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lim, ok := l.limiters[ip]
+	if !ok {
+		lim = rate.NewLimiter(l.rate, l.burst)
+		l.limiters[ip] = lim
+	}
+	return lim
+}
+
+// RateLimit wraps handler with a per-client-IP rate limiter, rejecting
+// requests over the limit with 429 Too Many Requests.
+func RateLimit(limiters *ipLimiters, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		if !limiters.get(ip).Allow() {
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,258 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	valve "github.com/TowelSoftware/Mastersteam/valve"
+)
+
+/*
+apiError is the structured JSON body every /v1 endpoint writes on
+failure, in place of the bare http.Error() string the older /search/ and
+/server/ endpoints return.
+*/
+type apiError struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{
+		Error:  fmt.Sprintf(format, args...),
+		Status: status,
+	})
+}
+
+/*
+newRouter builds the HTTP API with gorilla/mux instead of hand-rolled
+strings.Split(r.URL.String(), "/") parsing, so malformed paths 404
+cleanly and /v1/servers can compose every master filter as query
+parameters.
+*/
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+
+	r.HandleFunc("/v1/servers", httpV1Servers).Methods(http.MethodGet)
+
+	r.HandleFunc("/search/{appid}/{hostname}", httpMasterSearch).Methods(http.MethodGet)
+	r.HandleFunc("/server/{gameaddr}", httpServer).Methods(http.MethodGet)
+	r.HandleFunc("/history/server/{addr}", httpServerHistory).Methods(http.MethodGet)
+	r.HandleFunc("/history/player/{name}", httpPlayerHistory).Methods(http.MethodGet)
+
+	r.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+
+	return r
+}
+
+// httpV1Servers exposes every A2S master filter as a query parameter,
+// plus limit/timeout/concurrency knobs and an include= list selecting
+// which of the (expensive) players/rules sub-queries to run per server.
+func httpV1Servers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	master, err := newMasterServerQuerier(q.Get("source"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "%s", err.Error())
+		return
+	}
+	defer master.Close()
+
+	if err := applyV1Filters(master, q); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	opts, err := v1QueryOptions(q)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+
+	rs := newResultStream(w, r)
+	rs.begin()
+	timer := prometheus.NewTimer(queryLatency.WithLabelValues("v1_servers"))
+	err = runServerQuerier(master, rs, opts)
+	timer.ObserveDuration()
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "%s", err.Error())
+		return
+	}
+	rs.finish()
+}
+
+// extendedFilterKeys are the query parameters only MasterServerQuerier
+// (the UDP backend) implements; applyV1Filters rejects them outright
+// against a backend that doesn't.
+var extendedFilterKeys = []string{
+	"map", "gamedir", "empty", "full", "secure", "dedicated",
+	"napp", "region", "collapse_addr_hash", "nor", "nand",
+}
+
+// applyV1Filters maps every documented master filter query parameter onto
+// the matching valve.MasterQuerier builder method, validating numeric and
+// boolean parameters along the way.
+func applyV1Filters(master valve.MasterQuerier, q map[string][]string) error {
+	get := func(key string) string {
+		if v, ok := q[key]; ok && len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	if v := get("appid"); v != "" {
+		appID, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid appid %q: %s", v, err.Error())
+		}
+		master.FilterAppId(valve.AppId(appID))
+	}
+
+	if v := get("gameaddr"); v != "" {
+		master.FilterGameaddr(v)
+	}
+
+	if v := get("name_match"); v != "" {
+		master.FilterName(v)
+	}
+
+	type boolFilter struct {
+		key   string
+		apply func()
+	}
+
+	m, ok := master.(interface {
+		FilterMap(string)
+		FilterGamedir(string)
+		FilterEmpty()
+		FilterFull()
+		FilterSecure()
+		FilterDedicated()
+		FilterNapp(valve.AppId)
+		FilterRegion(int)
+		FilterCollapseAddrHash()
+		FilterNor(int, string)
+		FilterNand(int, string)
+	})
+	if !ok {
+		// The web backend doesn't implement the extended filter set yet;
+		// fail loudly instead of silently ignoring a filter the caller
+		// asked for but won't actually get applied.
+		for _, key := range extendedFilterKeys {
+			if get(key) != "" {
+				return fmt.Errorf("filter %q is not supported by the selected master source", key)
+			}
+		}
+		return nil
+	}
+
+	if v := get("map"); v != "" {
+		m.FilterMap(v)
+	}
+	if v := get("gamedir"); v != "" {
+		m.FilterGamedir(v)
+	}
+
+	for _, f := range []boolFilter{
+		{"empty", m.FilterEmpty},
+		{"full", m.FilterFull},
+		{"secure", m.FilterSecure},
+		{"dedicated", m.FilterDedicated},
+		{"collapse_addr_hash", m.FilterCollapseAddrHash},
+	} {
+		switch v := get(f.key); v {
+		case "1":
+			f.apply()
+		case "", "0":
+		default:
+			return fmt.Errorf("invalid %s %q: must be 0 or 1", f.key, v)
+		}
+	}
+
+	if v := get("napp"); v != "" {
+		nappID, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid napp %q: %s", v, err.Error())
+		}
+		m.FilterNapp(valve.AppId(nappID))
+	}
+
+	if v := get("region"); v != "" {
+		region, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid region %q: %s", v, err.Error())
+		}
+		m.FilterRegion(region)
+	}
+
+	if v := get("nor"); v != "" {
+		m.FilterNor(strings.Count(v, `\`)/2, v)
+	}
+	if v := get("nand"); v != "" {
+		m.FilterNand(strings.Count(v, `\`)/2, v)
+	}
+
+	return nil
+}
+
+func v1QueryOptions(q map[string][]string) (serverQueryOptions, error) {
+	opts := defaultServerQueryOptions()
+
+	get := func(key string) string {
+		if v, ok := q[key]; ok && len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	if v := get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid timeout %q: %s", v, err.Error())
+		}
+		opts.Timeout = d
+	}
+
+	if v := get("concurrency"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return opts, fmt.Errorf("invalid concurrency %q", v)
+		}
+		opts.Concurrency = n
+	}
+
+	if v := get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return opts, fmt.Errorf("invalid limit %q", v)
+		}
+		opts.Limit = n
+	}
+
+	opts.WithPlayers = false
+	opts.WithRules = false
+	for _, part := range strings.Split(get("include"), ",") {
+		switch strings.TrimSpace(part) {
+		case "players":
+			opts.WithPlayers = true
+		case "rules":
+			opts.WithRules = true
+		case "":
+		default:
+			return opts, fmt.Errorf("unknown include %q", part)
+		}
+	}
+
+	return opts, nil
+}
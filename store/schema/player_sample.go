@@ -0,0 +1,26 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/field"
+)
+
+// PlayerSample records one sighting of a named player on a server, taken
+// each time that server is queried and the player is present in its
+// player list. /history/player/{name} reads these back.
+type PlayerSample struct {
+	ent.Schema
+}
+
+func (PlayerSample) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("name").NotEmpty(),
+		field.String("server_address").NotEmpty(),
+		field.Int32("score"),
+		field.Float32("duration"),
+		field.Time("seen_at").Default(time.Now).Immutable(),
+	}
+}
@@ -0,0 +1,26 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/field"
+)
+
+// PopulationSample is one population data point for a server, recorded
+// every time that server answers a query. /history/server/{addr} is a
+// time-series read over these rows.
+type PopulationSample struct {
+	ent.Schema
+}
+
+func (PopulationSample) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("server_address").NotEmpty(),
+		field.String("map"),
+		field.Int("players"),
+		field.Int("max_players"),
+		field.Time("seen_at").Default(time.Now).Immutable(),
+	}
+}
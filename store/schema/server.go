@@ -0,0 +1,29 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package schema
+
+import (
+	"time"
+
+	"entgo.io/ent"
+	"entgo.io/ent/schema/field"
+)
+
+// Server is the ent schema for a queried game server's latest known state.
+// Every query that answers writes (or refreshes) one row here, so the
+// housekeeping goroutine knows which servers to re-query. The
+// /history/server/{addr} time-series is read from PopulationSample rows.
+type Server struct {
+	ent.Schema
+}
+
+func (Server) Fields() []ent.Field {
+	return []ent.Field{
+		field.String("address").NotEmpty().Unique(),
+		field.Int("appid"),
+		field.String("name"),
+		field.String("map"),
+		field.Int("players"),
+		field.Int("max_players"),
+		field.Time("last_seen").Default(time.Now).UpdateDefault(time.Now),
+	}
+}
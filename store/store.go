@@ -0,0 +1,203 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+
+// Package store persists every queried server and player sample so
+// trend/analytics endpoints like /history/server/{addr} and
+// /history/player/{name} have something to read, instead of discarding
+// each query's results as soon as the response is written.
+//
+// The ent client this package imports (store/ent/...) is generated from
+// the schemas in store/schema and is not committed; run `go generate
+// ./store/...` (which requires network access to fetch entgo.io/ent's
+// codegen tool) after checking out the repo, and again whenever a schema
+// file under store/schema changes, before building.
+//
+//go:generate go run -mod=mod entgo.io/ent/cmd/ent generate --feature sql/upsert ./schema
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/TowelSoftware/Mastersteam/store/ent"
+	"github.com/TowelSoftware/Mastersteam/store/ent/migrate"
+	"github.com/TowelSoftware/Mastersteam/store/ent/playersample"
+	"github.com/TowelSoftware/Mastersteam/store/ent/populationsample"
+	"github.com/TowelSoftware/Mastersteam/store/ent/server"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Store wraps an ent client with the queries the HTTP handlers and the
+// housekeeping goroutine need.
+type Store struct {
+	client *ent.Client
+}
+
+// Open connects to driverName/dataSourceName (e.g. "sqlite3",
+// "file:mastersteam.db?_fk=1", or "postgres", a libpq DSN) and ensures the
+// schema is up to date.
+//
+// migrateUnsafe additionally lets the migration drop indexes and columns
+// that exist in the database but not in the current schema. Leave it
+// false in production: it's only meant for throwaway dev/test databases,
+// since the ordinary (non-destructive) migration never removes data on
+// its own.
+func Open(driverName, dataSourceName string, migrateUnsafe bool) (*Store, error) {
+	client, err := ent.Open(driverName, dataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("could not open store: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var migrateOpts []migrate.SchemaOption
+	if migrateUnsafe {
+		migrateOpts = append(migrateOpts, migrate.WithDropIndex(true), migrate.WithDropColumn(true))
+	}
+
+	if err := client.Schema.Create(ctx, migrateOpts...); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("could not migrate store schema: %s", err.Error())
+	}
+
+	return &Store{client: client}, nil
+}
+
+func (s *Store) Close() error {
+	return s.client.Close()
+}
+
+// ServerSnapshot is what a single A2S_INFO answer contributes to the
+// store. It mirrors the subset of ServerObject the history endpoints
+// care about, kept separate so store doesn't import the main package.
+type ServerSnapshot struct {
+	Address    string
+	AppID      int
+	Name       string
+	MapName    string
+	Players    int
+	MaxPlayers int
+}
+
+// PlayerSighting is what a single A2S_PLAYER entry contributes to the
+// store.
+type PlayerSighting struct {
+	Name     string
+	Score    int32
+	Duration float32
+}
+
+// RecordServer upserts a server's latest known state and appends a
+// population sample for its history.
+func (s *Store) RecordServer(ctx context.Context, snap ServerSnapshot) error {
+	err := s.client.Server.Create().
+		SetAddress(snap.Address).
+		SetAppid(snap.AppID).
+		SetName(snap.Name).
+		SetMap(snap.MapName).
+		SetPlayers(snap.Players).
+		SetMaxPlayers(snap.MaxPlayers).
+		OnConflictColumns(server.FieldAddress).
+		UpdateNewValues().
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("could not upsert server %s: %s", snap.Address, err.Error())
+	}
+
+	err = s.client.PopulationSample.Create().
+		SetServerAddress(snap.Address).
+		SetMap(snap.MapName).
+		SetPlayers(snap.Players).
+		SetMaxPlayers(snap.MaxPlayers).
+		Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("could not record population sample for %s: %s", snap.Address, err.Error())
+	}
+
+	return nil
+}
+
+// RecordPlayers appends one sighting per player currently on addr.
+func (s *Store) RecordPlayers(ctx context.Context, addr string, players []PlayerSighting) error {
+	for _, p := range players {
+		err := s.client.PlayerSample.Create().
+			SetName(p.Name).
+			SetServerAddress(addr).
+			SetScore(p.Score).
+			SetDuration(p.Duration).
+			Exec(ctx)
+		if err != nil {
+			return fmt.Errorf("could not record sighting of %q on %s: %s", p.Name, addr, err.Error())
+		}
+	}
+	return nil
+}
+
+// PopulationPoint is one point on a server's population time-series.
+type PopulationPoint struct {
+	Map        string    `json:"map"`
+	Players    int       `json:"players"`
+	MaxPlayers int       `json:"max_players"`
+	SeenAt     time.Time `json:"seen_at"`
+}
+
+// ServerHistory returns addr's population time-series, oldest first.
+func (s *Store) ServerHistory(ctx context.Context, addr string) ([]PopulationPoint, error) {
+	samples, err := s.client.PopulationSample.Query().
+		Where(populationsample.ServerAddress(addr)).
+		Order(ent.Asc(populationsample.FieldSeenAt)).
+		All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not load history for %s: %s", addr, err.Error())
+	}
+
+	points := make([]PopulationPoint, len(samples))
+	for i, sample := range samples {
+		points[i] = PopulationPoint{
+			Map:        sample.Map,
+			Players:    sample.Players,
+			MaxPlayers: sample.MaxPlayers,
+			SeenAt:     sample.SeenAt,
+		}
+	}
+	return points, nil
+}
+
+// PlayerAppearance is one place a named player has been seen.
+type PlayerAppearance struct {
+	ServerAddress string    `json:"server_address"`
+	Score         int32     `json:"score"`
+	Duration      float32   `json:"duration"`
+	SeenAt        time.Time `json:"seen_at"`
+}
+
+// PlayerHistory returns every place name has been seen, oldest first.
+func (s *Store) PlayerHistory(ctx context.Context, name string) ([]PlayerAppearance, error) {
+	samples, err := s.client.PlayerSample.Query().
+		Where(playersample.Name(name)).
+		Order(ent.Asc(playersample.FieldSeenAt)).
+		All(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not load history for player %q: %s", name, err.Error())
+	}
+
+	appearances := make([]PlayerAppearance, len(samples))
+	for i, sample := range samples {
+		appearances[i] = PlayerAppearance{
+			ServerAddress: sample.ServerAddress,
+			Score:         sample.Score,
+			Duration:      sample.Duration,
+			SeenAt:        sample.SeenAt,
+		}
+	}
+	return appearances, nil
+}
+
+// KnownAddresses returns every server address the store has seen, for the
+// housekeeping goroutine to re-query on an interval.
+func (s *Store) KnownAddresses(ctx context.Context) ([]string, error) {
+	return s.client.Server.Query().Select(server.FieldAddress).Strings(ctx)
+}
@@ -0,0 +1,80 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import "fmt"
+
+/*
+The filter methods below extend FilterAppId/FilterName/FilterGameaddr with
+the rest of the master-server filters Valve documents, so callers building
+a /v1/servers request don't have to fall back to raw filter strings for
+anything but \nor\ and \nand\. MasterServerQuerier has no exported setter
+for its filters slice, so these append to it directly, the same way
+FilterAppId/FilterName/FilterGameaddr already do.
+*/
+
+func (m *MasterServerQuerier) appendFilter(key, value string) {
+	m.filters = append(m.filters, fmt.Sprintf(`\%s\%s`, key, value))
+}
+
+// FilterMap restricts results to servers currently running mapName.
+func (m *MasterServerQuerier) FilterMap(mapName string) {
+	m.appendFilter("map", mapName)
+}
+
+// FilterGamedir restricts results to servers running the given game mod
+// (the game's directory name, e.g. "cstrike").
+func (m *MasterServerQuerier) FilterGamedir(gamedir string) {
+	m.appendFilter("gamedir", gamedir)
+}
+
+// FilterEmpty restricts results to servers that are not empty.
+func (m *MasterServerQuerier) FilterEmpty() {
+	m.appendFilter("empty", "1")
+}
+
+// FilterFull restricts results to servers that are not full.
+func (m *MasterServerQuerier) FilterFull() {
+	m.appendFilter("full", "1")
+}
+
+// FilterSecure restricts results to VAC-secured servers.
+func (m *MasterServerQuerier) FilterSecure() {
+	m.appendFilter("secure", "1")
+}
+
+// FilterDedicated restricts results to dedicated servers.
+func (m *MasterServerQuerier) FilterDedicated() {
+	m.appendFilter("dedicated", "1")
+}
+
+// FilterNapp excludes servers running appid, the inverse of FilterAppId.
+func (m *MasterServerQuerier) FilterNapp(id AppId) {
+	m.appendFilter("napp", fmt.Sprintf("%d", id))
+}
+
+// FilterRegion restricts results to a Valve master region code (see
+// Valve's master server query protocol for the region table).
+func (m *MasterServerQuerier) FilterRegion(region int) {
+	m.appendFilter("region", fmt.Sprintf("%d", region))
+}
+
+// FilterCollapseAddrHash deduplicates servers that share an address hash
+// (i.e. differ only by query port), keeping one per hash.
+func (m *MasterServerQuerier) FilterCollapseAddrHash() {
+	m.appendFilter("collapse_addr_hash", "1")
+}
+
+// FilterNor negates the logical OR of the n filters encoded in raw
+// (excluding servers that match any of them), which must already be in
+// Valve's "\key\value" filter syntax. Building
+// nested filter expressions from first principles is out of scope here;
+// this is a thin passthrough for callers that already have one.
+func (m *MasterServerQuerier) FilterNor(n int, raw string) {
+	m.appendFilter("nor", fmt.Sprintf("%d%s", n, raw))
+}
+
+// FilterNand is FilterNor's \nand\ counterpart: matches servers that fail
+// at least one of the n filters encoded in raw.
+func (m *MasterServerQuerier) FilterNand(n int, raw string) {
+	m.appendFilter("nand", fmt.Sprintf("%d%s", n, raw))
+}
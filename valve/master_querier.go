@@ -0,0 +1,27 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+/*
+MasterQuerier is satisfied by anything that can list servers matching a
+set of master-server filters and hand them to a callback as they're
+known. MasterServerQuerier (the UDP master protocol) implements it, as
+does WebMasterServerQuerier (the Steam Web API fallback), so callers can
+pick a backend without changing how they consume results.
+*/
+type MasterQuerier interface {
+	FilterAppId(id AppId)
+	FilterName(name string)
+	FilterGameaddr(addr string)
+
+	// Query takes MasterQueryCallback, not a bare func(ServerList) error,
+	// because that's the signature *MasterServerQuerier.Query already
+	// has; WebMasterServerQuerier.Query matches it too so both backends
+	// satisfy this interface.
+	Query(fn MasterQueryCallback) error
+
+	// Close releases any resources (e.g. the UDP master socket) held by
+	// the querier. Callers must call it once they're done with a
+	// MasterQuerier obtained for a single request. It doesn't return an
+	// error, matching *MasterServerQuerier.Close.
+	Close()
+}
@@ -0,0 +1,110 @@
+// Licensed under the GNU General Public License, version 3 or higher.
+package valve
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const webMasterServerListURL = "https://api.steampowered.com/IGameServersService/GetServerList/v1/"
+
+/*
+WebMasterServerQuerier is a MasterQuerier backed by Steam's
+IGameServersService.GetServerList Web API instead of the UDP master
+protocol. The UDP master is IP-rate-limited and frequently truncates
+large lists; the Web API has its own per-key quota instead, so it's
+useful as a fallback (or primary) backend.
+*/
+type WebMasterServerQuerier struct {
+	apiKey string
+	limit  int
+
+	filters []string
+}
+
+// NewWebMasterServerQuerier builds a MasterQuerier that calls
+// GetServerList with apiKey, a Steam Web API key.
+func NewWebMasterServerQuerier(apiKey string) *WebMasterServerQuerier {
+	return &WebMasterServerQuerier{
+		apiKey: apiKey,
+		limit:  5000,
+	}
+}
+
+func (w *WebMasterServerQuerier) addFilter(key, value string) {
+	w.filters = append(w.filters, fmt.Sprintf(`\%s\%s`, key, value))
+}
+
+// FilterAppId restricts results to servers running appid, same as the
+// \appid\ UDP master filter.
+func (w *WebMasterServerQuerier) FilterAppId(id AppId) {
+	w.addFilter("appid", strconv.Itoa(int(id)))
+}
+
+// FilterName restricts results to servers whose name contains name, same
+// as the \name_match\ UDP master filter.
+func (w *WebMasterServerQuerier) FilterName(name string) {
+	w.addFilter("name_match", name)
+}
+
+// FilterGameaddr restricts results to a single server address, same as
+// the \gameaddr\ UDP master filter.
+func (w *WebMasterServerQuerier) FilterGameaddr(addr string) {
+	w.addFilter("gameaddr", addr)
+}
+
+// Close is a no-op: WebMasterServerQuerier holds no resources between
+// calls to Query, only satisfying MasterQuerier so callers don't need to
+// special-case the backend they got.
+func (w *WebMasterServerQuerier) Close() {}
+
+/*
+Query makes a single GetServerList request, capped at the querier's limit
+(5000 servers), and invokes fn once with all of its results, mirroring
+the callback contract of the UDP MasterServerQuerier so callers work
+unchanged regardless of backend. No further pages are requested: servers
+beyond the limit are not returned.
+*/
+func (w *WebMasterServerQuerier) Query(fn MasterQueryCallback) error {
+	params := url.Values{}
+	params.Set("key", w.apiKey)
+	params.Set("filter", strings.Join(w.filters, ""))
+	params.Set("limit", strconv.Itoa(w.limit))
+
+	resp, err := http.Get(webMasterServerListURL + "?" + params.Encode())
+	if err != nil {
+		return fmt.Errorf("could not query GetServerList: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GetServerList returned %s", resp.Status)
+	}
+
+	var body struct {
+		Response struct {
+			Servers []struct {
+				Addr string `json:"addr"`
+			} `json:"servers"`
+		} `json:"response"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("could not decode GetServerList response: %s", err.Error())
+	}
+
+	servers := make(ServerList, 0, len(body.Response.Servers))
+	for _, s := range body.Response.Servers {
+		addr, err := net.ResolveTCPAddr("tcp", s.Addr)
+		if err != nil {
+			continue
+		}
+		servers = append(servers, addr)
+	}
+
+	return fn(servers)
+}